@@ -0,0 +1,215 @@
+// Package allocator maps the per-eightbyte Classification produced by
+// parsers/x86_64 onto the concrete registers and stack slots a System V
+// AMD64 call actually uses. Classification tells you what class a value
+// has; AllocateCall tells you where it lands.
+package allocator
+
+import (
+	"github.com/vsoch/gosmeagle/parsers/x86_64"
+)
+
+// LocationKind identifies where a single eightbyte of a parameter or
+// return value lives.
+type LocationKind int
+
+const (
+	RegGP  LocationKind = iota // A general purpose register (rdi, rsi, ...)
+	RegSSE                     // An SSE register (xmm0, xmm1, ...)
+	RegX87                     // An x87 FPU stack register (st0, st1)
+	Stack                      // An offset into the argument area on the stack
+)
+
+func (k LocationKind) String() string {
+	switch k {
+	case RegGP:
+		return "RegGP"
+	case RegSSE:
+		return "RegSSE"
+	case RegX87:
+		return "RegX87"
+	case Stack:
+		return "Stack"
+	}
+	return "UNKNOWN"
+}
+
+// Location is where a single eightbyte lands: a named register, or an
+// 8-byte-aligned offset into the stack argument area.
+type Location struct {
+	Kind   LocationKind
+	Name   string // register name, set for RegGP/RegSSE/RegX87
+	Offset int64  // stack offset, set for Stack
+}
+
+// ParamLocation is where a single parameter landed: one Location per
+// eightbyte it occupies, or a single Stack Location if the whole parameter
+// was spilled.
+type ParamLocation struct {
+	Locations []Location
+}
+
+// CallLocation is the full register/stack assignment for a call: one
+// ParamLocation per parameter, in order, plus the Location(s) of the
+// return value.
+type CallLocation struct {
+	Params []ParamLocation
+	Return []Location
+}
+
+// System V AMD64 integer and SSE argument registers, in allocation order.
+var gpArgRegisters = []string{"rdi", "rsi", "rdx", "rcx", "r8", "r9"}
+var sseArgRegisters = []string{"xmm0", "xmm1", "xmm2", "xmm3", "xmm4", "xmm5", "xmm6", "xmm7"}
+
+// eightbytes returns the per-eightbyte vector for a Classification, falling
+// back to its Lo/Hi pair for classifications that don't carry one.
+func eightbytes(c x86_64.Classification) []x86_64.RegisterClass {
+	if len(c.Eightbytes) > 0 {
+		return c.Eightbytes
+	}
+	ebs := []x86_64.RegisterClass{c.Lo}
+	if c.Hi != x86_64.NO_CLASS {
+		ebs = append(ebs, c.Hi)
+	}
+	return ebs
+}
+
+// isSSEClass reports whether a register class occupies an SSE register.
+func isSSEClass(c x86_64.RegisterClass) bool {
+	switch c {
+	case x86_64.SSE, x86_64.SSEUP, x86_64.SSEFs, x86_64.SSEFv, x86_64.SSEDs, x86_64.SSEDv, x86_64.SSEInt:
+		return true
+	}
+	return false
+}
+
+// alwaysMemory reports whether a register class is always passed or
+// returned via the stack, regardless of how many registers are free.
+func alwaysMemory(c x86_64.RegisterClass) bool {
+	return c == x86_64.MEMORY || c == x86_64.X87 || c == x86_64.X87UP || c == x86_64.COMPLEX_X87
+}
+
+// needs returns how many GP and SSE registers a classification requires -
+// an SSEUP eightbyte shares the xmm register of the SSE eightbyte before
+// it, so only non-continuation SSE eightbytes count - and whether it must
+// go to the stack regardless of register availability.
+func needs(c x86_64.Classification) (gp int, sse int, mustSpill bool) {
+	for _, eb := range eightbytes(c) {
+		switch {
+		case alwaysMemory(eb):
+			mustSpill = true
+		case eb == x86_64.INTEGER:
+			gp++
+		case eb == x86_64.SSEUP:
+			// continuation of the previous SSE eightbyte, no new register
+		case isSSEClass(eb):
+			sse++
+		}
+	}
+	return
+}
+
+func align8(n int64) int64 {
+	return (n + 7) &^ 7
+}
+
+// AllocateCall walks params and ret the way the System V AMD64 ABI does:
+// each eightbyte of each parameter takes the next free integer or SSE
+// register, and the whole parameter spills to the stack - never split
+// across registers and stack - if it needs more registers than remain.
+func AllocateCall(params []x86_64.Classification, ret x86_64.Classification) CallLocation {
+
+	gpIdx := 0
+	sseIdx := 0
+	var stackOff int64
+
+	if retNeedsHiddenPointer(ret) {
+		// The caller passes a pointer to the return buffer as the first
+		// integer argument; rax echoes it back on return.
+		gpIdx++
+	}
+
+	result := CallLocation{Params: make([]ParamLocation, len(params))}
+
+	for i, param := range params {
+		ebs := eightbytes(param)
+		gpNeeded, sseNeeded, mustSpill := needs(param)
+
+		fitsInRegisters := !mustSpill &&
+			gpIdx+gpNeeded <= len(gpArgRegisters) &&
+			sseIdx+sseNeeded <= len(sseArgRegisters)
+
+		if !fitsInRegisters {
+			stackOff = align8(stackOff)
+			result.Params[i] = ParamLocation{Locations: []Location{{Kind: Stack, Offset: stackOff}}}
+			stackOff += align8(int64(len(ebs)) * 8)
+			continue
+		}
+
+		locs := make([]Location, 0, len(ebs))
+		for _, eb := range ebs {
+			switch {
+			case eb == x86_64.INTEGER:
+				locs = append(locs, Location{Kind: RegGP, Name: gpArgRegisters[gpIdx]})
+				gpIdx++
+			case eb == x86_64.SSEUP:
+				locs = append(locs, Location{Kind: RegSSE, Name: sseArgRegisters[sseIdx-1]})
+			default:
+				locs = append(locs, Location{Kind: RegSSE, Name: sseArgRegisters[sseIdx]})
+				sseIdx++
+			}
+		}
+		result.Params[i] = ParamLocation{Locations: locs}
+	}
+
+	result.Return = allocateReturn(ret)
+	return result
+}
+
+// retNeedsHiddenPointer reports whether the return value is passed back
+// through a caller-supplied buffer rather than in registers. Unlike a
+// parameter, a return value only takes this path for MEMORY - X87/X87UP/
+// COMPLEX_X87 still return via st0/st1 and consume no argument register.
+func retNeedsHiddenPointer(ret x86_64.Classification) bool {
+	for _, eb := range eightbytes(ret) {
+		if eb == x86_64.MEMORY {
+			return true
+		}
+	}
+	return false
+}
+
+// allocateReturn classifies where the return value lands: rax/rdx for
+// INTEGER eightbytes, xmm0/xmm1 for SSE eightbytes, st0/st1 for an X87 pair
+// or COMPLEX_X87, or rax holding the hidden buffer pointer for MEMORY.
+func allocateReturn(ret x86_64.Classification) []Location {
+
+	if retNeedsHiddenPointer(ret) {
+		return []Location{{Kind: RegGP, Name: "rax"}}
+	}
+
+	intRegisters := []string{"rax", "rdx"}
+	sseRegisters := []string{"xmm0", "xmm1"}
+	x87Registers := []string{"st0", "st1"}
+
+	intIdx, sseIdx, x87Idx := 0, 0, 0
+	var locs []Location
+	for _, eb := range eightbytes(ret) {
+		switch {
+		case eb == x86_64.INTEGER:
+			locs = append(locs, Location{Kind: RegGP, Name: intRegisters[intIdx]})
+			intIdx++
+		case eb == x86_64.SSEUP:
+			locs = append(locs, Location{Kind: RegSSE, Name: sseRegisters[sseIdx-1]})
+		case isSSEClass(eb):
+			locs = append(locs, Location{Kind: RegSSE, Name: sseRegisters[sseIdx]})
+			sseIdx++
+		case eb == x86_64.COMPLEX_X87:
+			// real part in st0, imaginary part in st1
+			locs = append(locs, Location{Kind: RegX87, Name: "st0"}, Location{Kind: RegX87, Name: "st1"})
+		case eb == x86_64.X87 || eb == x86_64.X87UP:
+			locs = append(locs, Location{Kind: RegX87, Name: x87Registers[x87Idx]})
+			x87Idx++
+		}
+	}
+	return locs
+}
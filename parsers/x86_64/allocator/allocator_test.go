@@ -0,0 +1,110 @@
+package allocator
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vsoch/gosmeagle/parsers/x86_64"
+)
+
+// m128Classification mirrors what ClassifyBasic now returns for a bare
+// __m128 parameter: one eightbyte of SSEFv, continued by SSEUP so it only
+// ever claims a single xmm register.
+func m128Classification() x86_64.Classification {
+	return x86_64.Classification{Lo: x86_64.SSEFv, Hi: x86_64.SSEUP, Name: "FloatVec"}
+}
+
+func intClassification() x86_64.Classification {
+	return x86_64.Classification{Lo: x86_64.INTEGER, Hi: x86_64.NO_CLASS, Name: "Integer"}
+}
+
+// TestAllocateCallM128OneRegisterEach is a regression test for the
+// Hi:SSEUP fix - three __m128 parameters must land in xmm0, xmm1, xmm2,
+// not xmm0, xmm2, xmm4 (which is what happens if Hi duplicates Lo's
+// sub-variant instead of marking a continuation).
+func TestAllocateCallM128OneRegisterEach(t *testing.T) {
+	params := []x86_64.Classification{m128Classification(), m128Classification(), m128Classification()}
+	got := AllocateCall(params, x86_64.Classification{Lo: x86_64.NO_CLASS, Hi: x86_64.NO_CLASS})
+
+	want := []string{"xmm0", "xmm1", "xmm2"}
+	for i, w := range want {
+		locs := got.Params[i].Locations
+		if len(locs) != 2 || locs[0].Kind != RegSSE || locs[0].Name != w {
+			t.Fatalf("param %d: got %+v, want first location in %s", i, locs, w)
+		}
+		if locs[1].Kind != RegSSE || locs[1].Name != w {
+			t.Fatalf("param %d: SSEUP half should stay in %s, got %+v", i, w, locs[1])
+		}
+	}
+}
+
+// TestAllocateCallReturnLongDoubleUsesX87 is a regression test for the
+// return-value hidden-pointer fix - an X87 pair must return via st0/st1,
+// not a hidden buffer pointer in rax, and must not reserve rdi for
+// parameters.
+func TestAllocateCallReturnLongDoubleUsesX87(t *testing.T) {
+	ret := x86_64.Classification{Lo: x86_64.X87, Hi: x86_64.X87UP, Name: "Float"}
+	params := []x86_64.Classification{intClassification()}
+
+	got := AllocateCall(params, ret)
+
+	want := []Location{{Kind: RegX87, Name: "st0"}, {Kind: RegX87, Name: "st1"}}
+	if !reflect.DeepEqual(got.Return, want) {
+		t.Fatalf("Return = %+v, want %+v", got.Return, want)
+	}
+
+	// rdi must go to the first (and only) integer parameter, not be
+	// reserved for a hidden return pointer.
+	locs := got.Params[0].Locations
+	if len(locs) != 1 || locs[0].Kind != RegGP || locs[0].Name != "rdi" {
+		t.Fatalf("Params[0] = %+v, want a single rdi location", locs)
+	}
+}
+
+// TestAllocateCallMemoryReturnUsesHiddenPointer checks the MEMORY return
+// path still reserves rdi and echoes the buffer pointer back in rax.
+func TestAllocateCallMemoryReturnUsesHiddenPointer(t *testing.T) {
+	ret := x86_64.Classification{
+		Lo: x86_64.MEMORY, Hi: x86_64.MEMORY, Name: "Struct",
+		Eightbytes: []x86_64.RegisterClass{x86_64.MEMORY, x86_64.MEMORY, x86_64.MEMORY},
+	}
+	params := []x86_64.Classification{intClassification()}
+
+	got := AllocateCall(params, ret)
+
+	want := []Location{{Kind: RegGP, Name: "rax"}}
+	if !reflect.DeepEqual(got.Return, want) {
+		t.Fatalf("Return = %+v, want %+v", got.Return, want)
+	}
+
+	// rdi is consumed by the hidden pointer, so the first real parameter
+	// moves to rsi.
+	locs := got.Params[0].Locations
+	if len(locs) != 1 || locs[0].Kind != RegGP || locs[0].Name != "rsi" {
+		t.Fatalf("Params[0] = %+v, want a single rsi location", locs)
+	}
+}
+
+// TestAllocateCallSpillsWholeMemoryParam checks a MEMORY-class parameter
+// spills entirely to the stack rather than partially consuming registers.
+func TestAllocateCallSpillsWholeMemoryParam(t *testing.T) {
+	memParam := x86_64.Classification{
+		Lo: x86_64.MEMORY, Hi: x86_64.MEMORY, Name: "Struct",
+		Eightbytes: []x86_64.RegisterClass{x86_64.MEMORY, x86_64.MEMORY, x86_64.MEMORY},
+	}
+	params := []x86_64.Classification{memParam, intClassification()}
+
+	got := AllocateCall(params, x86_64.Classification{Lo: x86_64.NO_CLASS, Hi: x86_64.NO_CLASS})
+
+	locs := got.Params[0].Locations
+	if len(locs) != 1 || locs[0].Kind != Stack || locs[0].Offset != 0 {
+		t.Fatalf("Params[0] = %+v, want a single Stack location at offset 0", locs)
+	}
+
+	// The integer parameter after it still gets rdi - the spill didn't
+	// consume a GP register.
+	intLocs := got.Params[1].Locations
+	if len(intLocs) != 1 || intLocs[0].Kind != RegGP || intLocs[0].Name != "rdi" {
+		t.Fatalf("Params[1] = %+v, want a single rdi location", intLocs)
+	}
+}
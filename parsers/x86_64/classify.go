@@ -20,6 +20,16 @@ const (
 	COMPLEX_X87                      // Types that will be returned via the x87 FPU
 	NO_CLASS                         // Initalizer in the algorithms, used for padding and empty structs/unions
 	MEMORY                           // Types that will be passed and returned in memory via the stack
+
+	// SSE sub-variants. These all count as SSE for calling-convention
+	// decisions (see isSSE), but are kept distinct so a downstream consumer
+	// can pick the right move: MOVSS/MOVSD for a scalar, MOVAPS/MOVAPD for
+	// a packed vector, MOVDQA for integer SSE data.
+	SSEFs  // Scalar float in the low 32 bits of the register
+	SSEFv  // Packed floats
+	SSEDs  // Scalar double in the low 64 bits of the register
+	SSEDv  // Packed doubles
+	SSEInt // Integer SSE data; width in bits is Classification.SSEIntBits
 )
 
 func (r RegisterClass) String() string {
@@ -40,15 +50,44 @@ func (r RegisterClass) String() string {
 		return "NO_CLASS"
 	case MEMORY:
 		return "MEMORY"
+	case SSEFs:
+		return "SSEFs"
+	case SSEFv:
+		return "SSEFv"
+	case SSEDs:
+		return "SSEDs"
+	case SSEDv:
+		return "SSEDv"
+	case SSEInt:
+		return "SSEInt"
 	}
 	return "UNKNOWN"
 }
 
+// isSSE reports whether r is SSE or one of its scalar/vector/integer
+// sub-variants. Calling-convention decisions (postMergeEightbytes) treat
+// every sub-variant the same as plain SSE.
+func isSSE(r RegisterClass) bool {
+	switch r {
+	case SSE, SSEFs, SSEFv, SSEDs, SSEDv, SSEInt:
+		return true
+	}
+	return false
+}
+
 type Classification struct {
 	Lo                  RegisterClass
 	Hi                  RegisterClass
 	Name                string
 	PointerIndirections int64
+	// Eightbytes holds one RegisterClass per eightbyte of the aggregate, in
+	// offset order. For types up to 16 bytes, Lo/Hi are Eightbytes[0]/[1]
+	// kept around as a convenience for callers that only care about the
+	// classic two-slot case.
+	Eightbytes []RegisterClass
+	// SSEIntBits is the bit width (8/16/32/64/128) of integer SSE data when
+	// Lo or Hi is SSEInt; zero otherwise.
+	SSEIntBits uint8
 }
 
 // ClassifyPointer will classify a pointer
@@ -56,44 +95,207 @@ func ClassifyPointer(ptrCount *int64) Classification {
 	return Classification{Lo: INTEGER, Hi: NO_CLASS, Name: "Pointer", PointerIndirections: (*ptrCount)}
 }
 
-// ClassifyArray will classify an array
+// newEightbytes allocates the per-eightbyte classification vector for an
+// aggregate of the given size (in bytes), initialized to NO_CLASS.
+func newEightbytes(size int64) []RegisterClass {
+	n := (size + 7) / 8
+	if n <= 0 {
+		n = 1
+	}
+	classes := make([]RegisterClass, n)
+	for i := range classes {
+		classes[i] = NO_CLASS
+	}
+	return classes
+}
+
+// classificationFromEightbytes builds the final Classification from a
+// fully merged and post-merged eightbyte vector. bits carries the
+// SSEIntBits of whichever field contributed to each eightbyte, so that an
+// aggregate whose Lo/Hi ends up SSEInt still reports the right width.
+func classificationFromEightbytes(name string, classes []RegisterClass, bits []uint8) Classification {
+	lo := NO_CLASS
+	hi := NO_CLASS
+	if len(classes) > 0 {
+		lo = classes[0]
+	}
+	if len(classes) > 1 {
+		hi = classes[1]
+	}
+	var sseIntBits uint8
+	if lo == SSEInt && len(bits) > 0 {
+		sseIntBits = bits[0]
+	} else if hi == SSEInt && len(bits) > 1 {
+		sseIntBits = bits[1]
+	}
+	return Classification{Lo: lo, Hi: hi, Name: name, Eightbytes: classes, SSEIntBits: sseIntBits}
+}
+
+// mergeFieldIntoEightbytes merges a single field's classification into the
+// eightbyte(s) of the parent aggregate that it occupies, starting at the
+// field's DWARF ByteOffset. The number of eightbytes it occupies comes from
+// its offset and size, not from how many slots its own Classification
+// happens to carry - a plain 8-byte double, for example, classifies as
+// Lo:SSEDs/Hi:SSEUP on its own, but only ever owns one eightbyte once it's
+// sitting inside a struct. A field that does straddle a boundary
+// contributes its Lo class to the first eightbyte and its Hi class to the
+// second, exactly like the two-slot merge did for a whole aggregate.
+//
+// bits tracks, per eightbyte, the widest SSEIntBits of any field that
+// contributed SSEInt to that eightbyte, so a struct/union/array field that's itself an
+// __m64/__m128i keeps its width visible on the aggregate's Classification.
+func mergeFieldIntoEightbytes(classes []RegisterClass, bits []uint8, offset int64, size int64, field Classification) {
+
+	start := offset / 8
+	end := (offset + size - 1) / 8
+	span := int(end-start) + 1
+
+	ebs := field.Eightbytes
+	if len(ebs) == 0 {
+		// The field's own Classification only carries two slots (Lo/Hi), but
+		// its span can be wider than that - e.g. a >16-byte vector intrinsic
+		// that ClassifyBasic can't break into a full Eightbytes vector. Fill
+		// every eightbyte the field actually spans, repeating Hi (or Lo, if
+		// there's no Hi) past the second slot, rather than silently leaving
+		// the rest at NO_CLASS.
+		ebs = make([]RegisterClass, span)
+		ebs[0] = field.Lo
+		for i := 1; i < span; i++ {
+			hi := field.Hi
+			if hi == NO_CLASS {
+				hi = field.Lo
+			}
+			ebs[i] = hi
+		}
+	}
+
+	for i := 0; i < span && i < len(ebs); i++ {
+		idx := start + int64(i)
+		if idx < 0 || int(idx) >= len(classes) {
+			continue
+		}
+		classes[idx] = merge(classes[idx], ebs[i])
+		if ebs[i] == SSEInt && field.SSEIntBits > bits[idx] {
+			bits[idx] = field.SSEIntBits
+		}
+	}
+}
+
+// ClassifyArray will classify an array by merging every element's
+// classification into the eightbytes it occupies, the same way a struct
+// merges its fields.
 func ClassifyArray(t *dwarf.ArrayType, c *file.Component, ptrCount *int64) Classification {
 
-	size := t.Type.Size()
-	if size > 64 {
-		return Classification{Lo: MEMORY, Hi: NO_CLASS, Name: "Array"}
+	size := t.CommonType.Size()
+	classes := newEightbytes(size)
+	bits := make([]uint8, len(classes))
+
+	elementSize := t.Type.Size()
+	if elementSize <= 0 {
+		elementSize = size
+	}
+	if elementSize <= 0 {
+		// Neither the array nor its element type carries a byte_size (e.g.
+		// a flexible/incomplete-element array member) - there's nothing to
+		// walk field-by-field, so fall back to MEMORY rather than divide
+		// by zero below.
+		return Classification{Lo: MEMORY, Hi: MEMORY, Name: "Array"}
+	}
+
+	elementComponent := file.Component{Name: c.Name, Class: file.GetStringType(t.Type),
+		Size: elementSize, RawType: t.Type}
+	elementClass := ClassifyType(&elementComponent, ptrCount)
+
+	count := size / elementSize
+	for i := int64(0); i < count; i++ {
+		mergeFieldIntoEightbytes(classes, bits, i*elementSize, elementSize, elementClass)
 	}
 
-	// Just classify the base type
-	return ClassifyType(c, ptrCount)
+	postMergeEightbytes(classes, size)
+	retagSSEVector(classes, t.CommonType.Name)
+	return classificationFromEightbytes("Array", classes, bits)
 }
 
-// ClassifyStruct classifies a struct
+// retagSSEVector replaces any SSE-family eightbyte (canonical SSE, or a
+// scalar sub-variant left behind when merging several identical-subvariant
+// elements - merge returns its input unchanged when both sides already
+// match) with the packed float/double/integer sub-variant when name
+// identifies a known vector intrinsic (__m128/__m128d/__m128i/__m256[d/i]/
+// __m512[d/i]). DWARF otherwise reports these as a plain array, so the
+// name is the only signal.
+func retagSSEVector(classes []RegisterClass, name string) {
+	if !strings.Contains(name, "__m") {
+		return
+	}
+
+	variant := SSEFv
+	switch {
+	case strings.HasSuffix(name, "i"):
+		variant = SSEInt
+	case strings.HasSuffix(name, "d"):
+		variant = SSEDv
+	}
+
+	for i, eb := range classes {
+		if isSSE(eb) {
+			classes[i] = variant
+		}
+	}
+}
+
+// ClassifyStruct classifies a struct by walking its fields with their DWARF
+// ByteOffset and merging each field's classification into the eightbyte(s)
+// it occupies, then running the post-merge cleanup over the whole vector.
 func ClassifyStruct(t *dwarf.StructType, c *file.Component, ptrCount *int64) Classification {
 
 	size := t.CommonType.Size()
 	kind := strings.Title(t.Kind)
+	classes := newEightbytes(size)
+	bits := make([]uint8, len(classes))
+
+	for _, field := range t.Field {
 
-	if size > 64 {
-		return Classification{Lo: MEMORY, Hi: NO_CLASS, Name: kind}
+		c := file.Component{Name: field.Name, Class: file.GetStringType(field.Type),
+			Size: field.Type.Size(), RawType: field.Type}
+		fieldClass := ClassifyType(&c, ptrCount)
+		mergeFieldIntoEightbytes(classes, bits, field.ByteOffset, field.Type.Size(), fieldClass)
 	}
 
-	hi := NO_CLASS
-	lo := NO_CLASS
+	postMergeEightbytes(classes, size)
+	return classificationFromEightbytes(kind, classes, bits)
+}
+
+// ClassifyUnion classifies a union. Every field of a union starts at offset
+// 0, so unlike ClassifyStruct its fields can't simply be accumulated in
+// sequence: each variant is classified independently over the same
+// eightbyte range, and the resulting vectors are merged pointwise.
+func ClassifyUnion(t *dwarf.StructType, c *file.Component, ptrCount *int64) Classification {
+
+	size := t.CommonType.Size()
+	kind := strings.Title(t.Kind)
+	classes := newEightbytes(size)
+	bits := make([]uint8, len(classes))
 
-	// Merge fields into final classification
 	for _, field := range t.Field {
 
 		c := file.Component{Name: field.Name, Class: file.GetStringType(field.Type),
 			Size: field.Type.Size(), RawType: field.Type}
 		fieldClass := ClassifyType(&c, ptrCount)
-		hi = merge(hi, fieldClass.Hi)
-		lo = merge(lo, fieldClass.Lo)
+
+		variant := newEightbytes(size)
+		variantBits := make([]uint8, len(variant))
+		mergeFieldIntoEightbytes(variant, variantBits, 0, field.Type.Size(), fieldClass)
+
+		for i := range classes {
+			classes[i] = merge(classes[i], variant[i])
+			if variant[i] == SSEInt && variantBits[i] > bits[i] {
+				bits[i] = variantBits[i]
+			}
+		}
 	}
 
-	// Run post merge step
-	postMerge(&lo, &hi, size)
-	return Classification{Lo: lo, Hi: hi, Name: kind}
+	postMergeEightbytes(classes, size)
+	return classificationFromEightbytes(kind, classes, bits)
 }
 
 // Merge lo and hi, Page 21 (bottom) AMD64 ABI - method to come up with final classification based on two
@@ -130,36 +332,86 @@ func merge(originalReg RegisterClass, newReg RegisterClass) RegisterClass {
 		return MEMORY
 	}
 
+	// Two SSE sub-variants merge to a canonical SSE, except two integer
+	// SSE values, which stay SSEInt (the wider bitwidth wins, tracked
+	// separately on Classification.SSEIntBits).
+	if isSSE(originalReg) && isSSE(newReg) {
+		if originalReg == SSEInt && newReg == SSEInt {
+			return SSEInt
+		}
+		return SSE
+	}
+
 	// (f) Otherwise class SSE is used.
 	return SSE
 }
 
-// post_merge Page 22 AMD64 ABI point 5 - this is the most merger "cleanup"
-func postMerge(lo *RegisterClass, hi *RegisterClass, size int64) {
+// postMergeEightbytes runs the page 22 AMD64 ABI point 5 cleanup over a
+// whole eightbyte vector, rather than a single Lo/Hi pair, so it stays
+// correct for aggregates bigger than two eightbytes.
+func postMergeEightbytes(classes []RegisterClass, size int64) {
 
 	// (a) If one of the classes is MEMORY, the whole argument is passed in memory.
-	if (*lo) == MEMORY || (*hi) == MEMORY {
-		(*lo) = MEMORY
-		(*hi) = MEMORY
+	for _, eb := range classes {
+		if eb == MEMORY {
+			fillMemory(classes)
+			return
+		}
 	}
 
-	// (b) If X87UP is not preceded by X87, the whole argument is passed in memory.
-	if (*hi) == X87UP && (*lo) != X87 {
-		(*lo) = MEMORY
-		(*hi) = MEMORY
+	// (d) If SSEUP is not preceded by SSE or SSEUP, it is converted to SSE.
+	for i := 1; i < len(classes); i++ {
+		if classes[i] == SSEUP && !isSSE(classes[i-1]) && classes[i-1] != SSEUP {
+			classes[i] = SSE
+		}
 	}
 
-	// (c) If the size of the aggregate exceeds two eightbytes and the first eight- byte isn’t SSE
-	// or any other eightbyte isn’t SSEUP, the whole argument is passed in memory.
-	if size > 128 && ((*lo) != SSE || (*hi) != SSEUP) {
-		(*lo) = MEMORY
-		(*hi) = MEMORY
+	// (b) and (c) only make sense looking at the whole vector at once, so
+	// they live in their own pass - see Reclassify.
+	Reclassify(classes, size)
+}
+
+// fillMemory marks every eightbyte in the vector as MEMORY.
+func fillMemory(classes []RegisterClass) {
+	for i := range classes {
+		classes[i] = MEMORY
+	}
+}
+
+// Reclassify applies the two post-merge rules that only make sense once the
+// whole eightbyte vector is known, and so couldn't be expressed by the old
+// two-slot Lo/Hi classification:
+//
+//  1. If an X87UP eightbyte isn't immediately preceded by X87, the whole
+//     aggregate is passed in MEMORY.
+//  2. For aggregates bigger than two eightbytes (16 bytes), if the first
+//     eightbyte isn't SSE or any later eightbyte isn't SSEUP, the whole
+//     aggregate is passed in MEMORY.
+//
+// It mutates ebs in place and also returns it, so it can be used directly
+// in a test assertion.
+func Reclassify(ebs []RegisterClass, totalSize int64) []RegisterClass {
+
+	for i, eb := range ebs {
+		if eb == X87UP && (i == 0 || ebs[i-1] != X87) {
+			fillMemory(ebs)
+			return ebs
+		}
 	}
 
-	// (d) If SSEUP is // not preceded by SSE or SSEUP, it is converted to SSE.
-	if (*hi) == SSEUP && ((*lo) != SSE && (*lo) != SSEUP) {
-		(*hi) = SSE
+	if totalSize > 16 {
+		bad := !isSSE(ebs[0])
+		for i := 1; i < len(ebs); i++ {
+			if ebs[i] != SSEUP {
+				bad = true
+			}
+		}
+		if bad {
+			fillMemory(ebs)
+		}
 	}
+
+	return ebs
 }
 
 // ClassifyFunction classifies a function type
@@ -202,6 +454,9 @@ func ClassifyType(c *file.Component, ptrCount *int64) Classification {
 	// This case actually handles struct, union, and class
 	case "Struct":
 		convert := c.RawType.(*dwarf.StructType)
+		if convert.Kind == "union" {
+			return ClassifyUnion(convert, c, ptrCount)
+		}
 		return ClassifyStruct(convert, c, ptrCount)
 	default:
 		log.Fatalf("Unnacounted for class in classifyType", c.Class)
@@ -210,25 +465,50 @@ func ClassifyType(c *file.Component, ptrCount *int64) Classification {
 	return Classification{Lo: NO_CLASS, Hi: NO_CLASS, Name: "Unknown"}
 }
 
+// basicTypeName returns the underlying DWARF type name for c, so that
+// vector intrinsics (__m64, __m128, __m256, __m512 and their i/d variants)
+// can be told apart from a plain integer or float of the same size, which
+// is all c.Class/c.Size give us. Falls back to the component's own name
+// (e.g. a struct field name) when the raw type isn't a *dwarf.BasicType.
+func basicTypeName(c *file.Component) string {
+	if bt, ok := c.RawType.(*dwarf.BasicType); ok {
+		return bt.CommonType.Name
+	}
+	return c.Name
+}
+
 func ClassifyBasic(c *file.Component, ptrCount *int64) Classification {
 
 	size := c.Size
+	name := basicTypeName(c)
 
 	// Integral types
 	switch c.Class {
 	case "Uint", "Int", "Char", "Uchar", "Basic", "Bool":
+		if strings.Contains(name, "__m64") {
+			// MMX register: 64 bits of packed integer SSE data.
+			return Classification{Lo: SSEInt, Hi: NO_CLASS, Name: "Integer", SSEIntBits: 64}
+		}
+		if strings.Contains(name, "__m128i") {
+			// One xmm register: SSEUP marks the high half as a continuation
+			// of the same register, not a second one.
+			return Classification{Lo: SSEInt, Hi: SSEUP, Name: "IntegerVec", SSEIntBits: 128}
+		}
 		if size > 128 {
 			return Classification{Lo: SSE, Hi: SSEUP, Name: "IntegerVec"}
 		}
 		if size == 128 {
 			// __int128 is treated as struct{long,long};
 			// This is NOT correct, but we don't handle aggregates yet.
-			// How do we differentiate between __int128 and __m128i?
 			return Classification{Lo: SSE, Hi: NO_CLASS, Name: "Integer"}
 		}
 
-		// _Decimal32, _Decimal64, and __m64 are supposed to be SSE.
-		// TODO How can we differentiate them here?
+		if strings.Contains(name, "_Decimal32") {
+			return Classification{Lo: SSEFs, Hi: NO_CLASS, Name: "Integer"}
+		}
+		if strings.Contains(name, "_Decimal64") {
+			return Classification{Lo: SSEDs, Hi: NO_CLASS, Name: "Integer"}
+		}
 		return Classification{Lo: INTEGER, Hi: NO_CLASS, Name: "Integer"}
 
 	case "Complex":
@@ -241,17 +521,31 @@ func ClassifyBasic(c *file.Component, ptrCount *int64) Classification {
 		return Classification{Lo: MEMORY, Hi: NO_CLASS, Name: "CplxFloat"}
 
 	case "Float":
-		if size <= 64 {
-			// 32- or 64-bit floats
-			return Classification{Lo: SSE, Hi: SSEUP, Name: "Float"}
+		if strings.Contains(name, "__m128d") {
+			// One xmm register: SSEUP marks the high half as a continuation
+			// of the same register, not a second one.
+			return Classification{Lo: SSEDv, Hi: SSEUP, Name: "FloatVec"}
+		}
+		if strings.Contains(name, "__m128") {
+			return Classification{Lo: SSEFv, Hi: SSEUP, Name: "FloatVec"}
+		}
+		if size <= 4 {
+			// 32-bit float, scalar in the low bits of the register
+			return Classification{Lo: SSEFs, Hi: SSEUP, Name: "Float"}
+		}
+		if size <= 8 {
+			// 64-bit float, scalar in the low bits of the register
+			return Classification{Lo: SSEDs, Hi: SSEUP, Name: "Float"}
 		}
 		if size == 128 {
-			// x87 `long double` OR __m128[d]
-			// TODO: How do we differentiate the vector type here? Dyninst should help us
+			// x87 `long double`; __m128[d] is already handled by name above
 			return Classification{Lo: X87, Hi: X87UP, Name: "Float"}
 		}
 		if size > 128 {
-			return Classification{Lo: SSE, Hi: SSEUP, Name: "FloatVec"}
+			if strings.Contains(name, "d") {
+				return Classification{Lo: SSEDv, Hi: SSEUP, Name: "FloatVec"}
+			}
+			return Classification{Lo: SSEFv, Hi: SSEUP, Name: "FloatVec"}
 		}
 
 	//case *dwarf.PtrType:
@@ -0,0 +1,200 @@
+package x86_64
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vsoch/gosmeagle/parsers/file"
+	"github.com/vsoch/gosmeagle/pkg/debug/dwarf"
+)
+
+// DWARF base-type encodings (DW_ATE_*), per the DWARF spec - used below to
+// build fixture BasicTypes without depending on unexported constants.
+const (
+	dwAteFloat  int8 = 0x04
+	dwAteSigned int8 = 0x05
+)
+
+// basicField builds a *dwarf.StructField for a scalar member, the way a
+// compiler would emit one for a union/struct field.
+func basicField(name string, size int64, encoding int8) *dwarf.StructField {
+	return &dwarf.StructField{
+		Name:       name,
+		ByteOffset: 0,
+		Type: &dwarf.BasicType{
+			CommonType: dwarf.CommonType{Name: name, ByteSize: size},
+			BitSize:    size * 8,
+			Encoding:   encoding,
+		},
+	}
+}
+
+func TestClassifyUnionDoubleAndLong(t *testing.T) {
+	// union { double d; long i; } -> the double merges to SSE, the long
+	// merges to INTEGER at the same eightbyte; rule (d) says INTEGER wins.
+	u := &dwarf.StructType{
+		CommonType: dwarf.CommonType{Name: "u", ByteSize: 8},
+		Kind:       "union",
+		Field: []*dwarf.StructField{
+			basicField("d", 8, dwAteFloat),
+			basicField("i", 8, dwAteSigned),
+		},
+	}
+	var ptrCount int64
+	got := ClassifyUnion(u, &file.Component{Name: "u", Class: "Struct"}, &ptrCount)
+	if got.Lo != INTEGER {
+		t.Errorf("Lo = %v, want INTEGER", got.Lo)
+	}
+}
+
+func TestClassifyUnionFloatAndInt(t *testing.T) {
+	// union { float f; int i; } -> INTEGER, same reasoning as above.
+	u := &dwarf.StructType{
+		CommonType: dwarf.CommonType{Name: "u", ByteSize: 4},
+		Kind:       "union",
+		Field: []*dwarf.StructField{
+			basicField("f", 4, dwAteFloat),
+			basicField("i", 4, dwAteSigned),
+		},
+	}
+	var ptrCount int64
+	got := ClassifyUnion(u, &file.Component{Name: "u", Class: "Struct"}, &ptrCount)
+	if got.Lo != INTEGER {
+		t.Errorf("Lo = %v, want INTEGER", got.Lo)
+	}
+}
+
+func TestClassifyUnionVectorAndStruct(t *testing.T) {
+	// union { __m128 v; struct { double a, b; } s; } -> both variants are
+	// pure SSE over the same two eightbytes, so the merge stays SSE-ish
+	// (not MEMORY, not INTEGER).
+	inner := &dwarf.StructType{
+		CommonType: dwarf.CommonType{Name: "s", ByteSize: 16},
+		Kind:       "struct",
+		Field: []*dwarf.StructField{
+			{Name: "a", ByteOffset: 0, Type: &dwarf.BasicType{
+				CommonType: dwarf.CommonType{Name: "double", ByteSize: 8}, BitSize: 64, Encoding: dwAteFloat}},
+			{Name: "b", ByteOffset: 8, Type: &dwarf.BasicType{
+				CommonType: dwarf.CommonType{Name: "double", ByteSize: 8}, BitSize: 64, Encoding: dwAteFloat}},
+		},
+	}
+	u := &dwarf.StructType{
+		CommonType: dwarf.CommonType{Name: "u", ByteSize: 16},
+		Kind:       "union",
+		Field: []*dwarf.StructField{
+			{Name: "v", ByteOffset: 0, Type: &dwarf.BasicType{
+				CommonType: dwarf.CommonType{Name: "__m128", ByteSize: 16}, BitSize: 128, Encoding: dwAteFloat}},
+			{Name: "s", ByteOffset: 0, Type: inner},
+		},
+	}
+	var ptrCount int64
+	got := ClassifyUnion(u, &file.Component{Name: "u", Class: "Struct"}, &ptrCount)
+	if !isSSE(got.Lo) || !isSSE(got.Hi) {
+		t.Errorf("Lo/Hi = %v/%v, want both SSE-family", got.Lo, got.Hi)
+	}
+}
+
+func TestClassifyBasicDoubleIsScalarDouble(t *testing.T) {
+	// A bare 8-byte double must classify as SSEDs (scalar double), not
+	// SSEFs (scalar float) - c.Size is bytes, not bits, so the size
+	// thresholds that pick between the two must be in bytes too.
+	c := &file.Component{Name: "d", Class: "Float", Size: 8,
+		RawType: &dwarf.BasicType{CommonType: dwarf.CommonType{Name: "double", ByteSize: 8}, BitSize: 64, Encoding: dwAteFloat}}
+	var ptrCount int64
+	got := ClassifyBasic(c, &ptrCount)
+	if got.Lo != SSEDs {
+		t.Errorf("Lo = %v, want SSEDs", got.Lo)
+	}
+}
+
+func TestClassifyBasicFloatIsScalarFloat(t *testing.T) {
+	// A bare 4-byte float must classify as SSEFs.
+	c := &file.Component{Name: "f", Class: "Float", Size: 4,
+		RawType: &dwarf.BasicType{CommonType: dwarf.CommonType{Name: "float", ByteSize: 4}, BitSize: 32, Encoding: dwAteFloat}}
+	var ptrCount int64
+	got := ClassifyBasic(c, &ptrCount)
+	if got.Lo != SSEFs {
+		t.Errorf("Lo = %v, want SSEFs", got.Lo)
+	}
+}
+
+func TestClassifyArrayOfFloatsRetagsAsPackedVector(t *testing.T) {
+	// __m128 is commonly reported by DWARF as a plain array of four
+	// floats named "__m128". Merging four identical SSEFs elements
+	// leaves the eightbyte at SSEFs (merge(a, a) returns a unchanged),
+	// so retagSSEVector must recognize any SSE sub-variant, not just the
+	// canonical SSE, or the packed-vector retag never fires.
+	arr := &dwarf.ArrayType{
+		CommonType: dwarf.CommonType{Name: "__m128", ByteSize: 16},
+		Type:       &dwarf.BasicType{CommonType: dwarf.CommonType{Name: "float", ByteSize: 4}, BitSize: 32, Encoding: dwAteFloat},
+	}
+	var ptrCount int64
+	got := ClassifyArray(arr, &file.Component{Name: "v", Class: "Array"}, &ptrCount)
+	if got.Lo != SSEFv {
+		t.Errorf("Lo = %v, want SSEFv", got.Lo)
+	}
+}
+
+func TestMergeSSESubVariants(t *testing.T) {
+	cases := []struct {
+		a, b, want RegisterClass
+	}{
+		{SSEFs, SSEDs, SSE},
+		{SSEFv, SSEDv, SSE},
+		{SSEInt, SSEInt, SSEInt},
+		{SSEInt, SSEFs, SSE},
+		{INTEGER, SSEFs, INTEGER},
+		{MEMORY, SSEFs, MEMORY},
+		{NO_CLASS, SSEDs, SSEDs},
+	}
+	for _, c := range cases {
+		if got := merge(c.a, c.b); got != c.want {
+			t.Errorf("merge(%v, %v) = %v, want %v", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestReclassifyVectorTypesStaySSE(t *testing.T) {
+	// __m256: one SSE eightbyte followed by three SSEUP continuations of
+	// the same register - must NOT be forced to MEMORY by the >16-byte rule.
+	m256 := []RegisterClass{SSE, SSEUP, SSEUP, SSEUP}
+	got := Reclassify(append([]RegisterClass{}, m256...), 32)
+	if !reflect.DeepEqual(got, m256) {
+		t.Errorf("__m256 Reclassify = %v, want unchanged %v", got, m256)
+	}
+
+	// __m512: same shape, twice as many eightbytes.
+	m512 := []RegisterClass{SSE, SSEUP, SSEUP, SSEUP, SSEUP, SSEUP, SSEUP, SSEUP}
+	got = Reclassify(append([]RegisterClass{}, m512...), 64)
+	if !reflect.DeepEqual(got, m512) {
+		t.Errorf("__m512 Reclassify = %v, want unchanged %v", got, m512)
+	}
+}
+
+func TestReclassifyLargeNonVectorAggregateGoesMemory(t *testing.T) {
+	// A 24-byte struct{double, double, int} has eightbytes [SSE, SSE,
+	// INTEGER]: each double is a standalone SSE value that only spans its
+	// own eightbyte, not a continuation (SSEUP) of the one before it. Per
+	// the AMD64 ABI's >16-byte rule, only a genuine SSE/SSEUP run (like
+	// __m256) survives past two eightbytes - anything else, including
+	// back-to-back separate doubles, is passed in memory.
+	ebs := []RegisterClass{SSE, SSE, INTEGER}
+	got := Reclassify(ebs, 24)
+	for i, eb := range got {
+		if eb != MEMORY {
+			t.Errorf("eightbyte %d = %v, want MEMORY", i, eb)
+		}
+	}
+
+	// Same rule applies to an all-INTEGER 32-byte struct{long,long,long,long}:
+	// it's still more than two eightbytes with a first eightbyte that isn't
+	// SSE, so it's MEMORY-classified here; the allocator never even gets a
+	// chance to spill it for lack of GP registers.
+	ebs = []RegisterClass{INTEGER, INTEGER, INTEGER, INTEGER}
+	got = Reclassify(ebs, 32)
+	for i, eb := range got {
+		if eb != MEMORY {
+			t.Errorf("eightbyte %d = %v, want MEMORY", i, eb)
+		}
+	}
+}